@@ -0,0 +1,30 @@
+// Package corehttp provides utilities for the HTTP interfaces to IPFS.
+package corehttp
+
+import (
+	"net"
+	"net/http"
+
+	core "github.com/ipfs/go-ipfs/core"
+)
+
+// ServeOption registers some HTTP handlers on the given mux and returns the
+// mux (or a wrapping http.ServeMux, for options that need to intercept
+// requests before they reach the handlers registered so far).
+type ServeOption func(*core.IpfsNode, net.Listener, *http.ServeMux) (*http.ServeMux, error)
+
+// makeHandler threads a base mux through each ServeOption in order,
+// producing the single http.Handler that the gateway/API server listens
+// with.
+func makeHandler(n *core.IpfsNode, l net.Listener, options ...ServeOption) (http.Handler, error) {
+	topMux := http.NewServeMux()
+	mux := topMux
+	for _, option := range options {
+		var err error
+		mux, err = option(n, l, mux)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return topMux, nil
+}
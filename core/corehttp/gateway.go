@@ -0,0 +1,339 @@
+package corehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+	ipath "github.com/ipfs/go-ipfs/path"
+	ft "github.com/ipfs/go-ipfs/unixfs"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+
+	cid "gx/ipfs/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+)
+
+// gatewayHandler serves UnixFS content reachable under /ipfs/<cid>/... and
+// /ipns/<name>/... . One instance is shared across every request; all
+// per-request state lives on the *http.Request / http.ResponseWriter.
+type gatewayHandler struct {
+	node            *core.IpfsNode
+	writable        bool
+	gatewayPrefixes []string
+
+	// deserializedResponses mirrors Gateway.DeserializedResponses: when
+	// false this is a "trustless" gateway that refuses to do any work a
+	// verifying client couldn't also do itself (UnixFS directory
+	// listings, DAG-PB decoding, HAMT sharding), serving only raw blocks
+	// and CAR streams.
+	deserializedResponses bool
+}
+
+// GatewayOption returns a ServeOption that mounts the gateway at each of
+// the given path prefixes, e.g. GatewayOption(false, "/ipfs", "/ipns").
+// The handler answers only GET and HEAD; writable is stored on the
+// handler, but no write method is served either way.
+func GatewayOption(writable bool, paths ...string) ServeOption {
+	return func(n *core.IpfsNode, l net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		gateway := &gatewayHandler{
+			node:                  n,
+			writable:              writable,
+			gatewayPrefixes:       paths,
+			deserializedResponses: true,
+		}
+		if cfg, err := n.Repo.Config(); err == nil {
+			gateway.deserializedResponses = cfg.Gateway.DeserializedResponses
+		}
+		for _, p := range paths {
+			mux.Handle(p+"/", gateway)
+		}
+		return mux, nil
+	}
+}
+
+func (i *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	setCORSHeaders(w)
+
+	urlPath, err := i.stripGatewayPrefix(r)
+	if err != nil {
+		webError(w, "invalid path prefix", err, http.StatusBadRequest)
+		return
+	}
+
+	nd, resolvedCid, err := i.resolve(r, urlPath)
+	if err != nil {
+		if isIPNSPath(urlPath) && err == namesys.ErrResolveFailed {
+			webError(w, "Path Resolve error", err, http.StatusInternalServerError)
+			return
+		}
+		if !i.deserializedResponses {
+			i.refuseDeserialized(w)
+			return
+		}
+		if i.serve404Page(w, r, urlPath) {
+			return
+		}
+		webError(w, "Path Resolve error", err, http.StatusNotFound)
+		return
+	}
+
+	if isCARRequest(r) {
+		i.serveCAR(r.Context(), w, r, urlPath, resolvedCid)
+		return
+	}
+
+	if !i.deserializedResponses {
+		i.serveTrustless(w, r, nd, resolvedCid)
+		return
+	}
+
+	// The Etag must already reflect whether resolvedCid is a directory
+	// (weak, since a directory listing is gateway-rendered rather than
+	// raw content) before we can answer If-None-Match: a client holding a
+	// weak directory Etag must get a 304 with that same weak Etag back,
+	// not the strong form a file would use.
+	pn, ok := nd.(*dag.ProtoNode)
+	var isDir bool
+	if ok {
+		isDir, err = isUnixfsDir(pn)
+		if err != nil {
+			webError(w, "failed to parse UnixFS node", err, http.StatusInternalServerError)
+			return
+		}
+	}
+	etag := strongEtag(resolvedCid)
+	if isDir {
+		etag = weakEtag(resolvedCid)
+	}
+
+	if ifNoneMatchHits(r.Header.Get("If-None-Match"), resolvedCid) {
+		w.Header().Set("Etag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("X-Ipfs-Path", urlPath)
+	if roots, err := i.pathRoots(r.Context(), urlPath, resolvedCid); err == nil {
+		w.Header().Set("X-Ipfs-Roots", joinCids(roots))
+	}
+
+	if !ok {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Etag", etag)
+		w.Write(nd.RawData())
+		return
+	}
+
+	if !isDir {
+		dr, err := uio.NewDagReader(r.Context(), pn, i.node.DAG)
+		if err != nil {
+			webError(w, "failed to read file", err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Etag", etag)
+		io.Copy(w, dr)
+		return
+	}
+
+	if idx := findLink(pn, "index.html"); idx != nil {
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, i.gatewayPrefix(r)+originalPath(r)+"/", http.StatusFound)
+			return
+		}
+		idxNode, err := i.node.DAG.Get(r.Context(), idx.Cid)
+		if err != nil {
+			webError(w, "failed to resolve index.html", err, http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Etag", strongEtag(resolvedCid))
+		w.Write(idxNode.RawData())
+		return
+	}
+
+	w.Header().Set("Etag", etag)
+	i.serveDirListing(w, r, pn)
+}
+
+// isUnixfsDir reports whether pn's UnixFS Type (encoded in its protobuf
+// Data field) is Directory or HAMTShard. Unlike pn.Links() or pn.Data()
+// emptiness, this holds for both leaf and non-leaf nodes of either kind:
+// a chunked file has links too, and a directory's Data is never empty
+// (it always carries at least the Type field), so neither can stand in
+// for the real UnixFS type.
+func isUnixfsDir(pn *dag.ProtoNode) (bool, error) {
+	fsNode, err := ft.FromBytes(pn.Data())
+	if err != nil {
+		return false, err
+	}
+	switch fsNode.Type() {
+	case ft.TDirectory, ft.THAMTShard:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// resolve walks urlPath (an /ipfs/... or /ipns/... request path) down to
+// its terminal DAG node, following IPNS through the node's Namesys first
+// when necessary.
+func (i *gatewayHandler) resolve(r *http.Request, urlPath string) (dag.Node, cid.Cid, error) {
+	root, rest, err := i.resolveRoot(r.Context(), urlPath)
+	if err != nil {
+		return nil, cid.Cid{}, err
+	}
+
+	p := root
+	if len(rest) > 0 {
+		p = ipath.FromString(root.String() + "/" + strings.Join(rest, "/"))
+	}
+
+	nd, err := i.node.Resolver.ResolvePath(r.Context(), p)
+	if err != nil {
+		return nil, cid.Cid{}, err
+	}
+	return nd, nd.Cid(), nil
+}
+
+// resolveRoot turns the first segment of urlPath into an /ipfs/<cid> path,
+// following it through the node's Namesys first when it's an IPNS name,
+// and returns the remaining path segments to walk from there. Every
+// caller that needs to resolve intermediate segments along urlPath (CAR
+// streaming, X-Ipfs-Roots) must go through this so an IPNS name partway
+// down the path is only ever resolved once, in one place.
+func (i *gatewayHandler) resolveRoot(ctx context.Context, urlPath string) (ipath.Path, []string, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(urlPath, "/ipfs/"), "/ipns/")
+	parts := strings.Split(strings.TrimSuffix(trimmed, "/"), "/")
+
+	if isIPNSPath(urlPath) {
+		resolved, err := i.node.Namesys.Resolve(ctx, "/ipns/"+parts[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		return resolved, parts[1:], nil
+	}
+	return ipath.FromString("/ipfs/" + parts[0]), parts[1:], nil
+}
+
+func (i *gatewayHandler) serveDirListing(w http.ResponseWriter, r *http.Request, pn *dag.ProtoNode) {
+	links := pn.Links()
+	sort.Slice(links, func(a, b int) bool { return links[a].Name < links[b].Name })
+
+	// Paths shown and linked are the client's own (before any IPNS
+	// hostname rewrite), under the gateway prefix if one applies.
+	prefix := i.gatewayPrefix(r)
+	dirPath := strings.TrimSuffix(originalPath(r), "/")
+	parent := "/"
+	if idx := strings.LastIndex(dirPath, "/"); idx > 0 {
+		parent = dirPath[:idx+1]
+	}
+	escape := func(p string) string { return (&url.URL{Path: p}).EscapedPath() }
+
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, "<pre>\n")
+	fmt.Fprintf(w, "Index of %s\n\n", template.HTMLEscapeString(prefix+dirPath+"/"))
+	fmt.Fprintf(w, "<a href=\"%s\">..</a>\n", template.HTMLEscapeString(escape(prefix+parent)))
+	for _, l := range links {
+		href := escape(prefix + dirPath + "/" + l.Name)
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", template.HTMLEscapeString(href), template.HTMLEscapeString(l.Name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+func findLink(pn *dag.ProtoNode, name string) *dag.Link {
+	for _, l := range pn.Links() {
+		if l.Name == name {
+			return l
+		}
+	}
+	return nil
+}
+
+func (i *gatewayHandler) stripGatewayPrefix(r *http.Request) (string, error) {
+	p := r.URL.Path
+	if prefix := r.Header.Get("X-Ipfs-Gateway-Prefix"); prefix != "" {
+		if !hasPrefix(i.gatewayPrefixesConfig(), prefix) {
+			return "", errors.New("invalid path prefix: " + prefix)
+		}
+		p = prefix + p
+	}
+	return p, nil
+}
+
+// gatewayPrefix returns the X-Ipfs-Gateway-Prefix header when it is one
+// of the configured prefixes, and "" otherwise; it is put in front of the
+// links and redirects the gateway writes back.
+func (i *gatewayHandler) gatewayPrefix(r *http.Request) string {
+	prefix := r.Header.Get("X-Ipfs-Gateway-Prefix")
+	if prefix == "" || !hasPrefix(i.gatewayPrefixesConfig(), prefix) {
+		return ""
+	}
+	return prefix
+}
+
+// originalPath returns the request path as the client sent it, before
+// IPNSHostnameOption rewrote it to /ipns/<host>/... .
+func originalPath(r *http.Request) string {
+	if p := r.Header.Get("X-Ipns-Original-Path"); p != "" {
+		return p
+	}
+	return r.URL.Path
+}
+
+// gatewayPrefixesConfig returns the set of path prefixes this gateway is
+// configured to accept via X-Ipfs-Gateway-Prefix, as stored under
+// Gateway.PathPrefixes in the node's repo config.
+func (i *gatewayHandler) gatewayPrefixesConfig() []string {
+	cfg, err := i.node.Repo.Config()
+	if err != nil {
+		return nil
+	}
+	return cfg.Gateway.PathPrefixes
+}
+
+func hasPrefix(prefixes []string, prefix string) bool {
+	for _, p := range prefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func isIPNSPath(p string) bool {
+	return strings.HasPrefix(p, "/ipns/")
+}
+
+// setCORSHeaders marks a gateway response as fetchable cross-origin. It
+// must be applied to every response that can be reached by a browser
+// fetch(), including redirects, or a preflight will fail before the
+// client ever sees them.
+func setCORSHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET")
+}
+
+func webError(w http.ResponseWriter, message string, err error, defaultCode int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(defaultCode)
+	if err == nil {
+		fmt.Fprint(w, message)
+		return
+	}
+	fmt.Fprintf(w, "%s: %s", message, err)
+}
@@ -0,0 +1,66 @@
+package corehttp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+	uio "github.com/ipfs/go-ipfs/unixfs/io"
+)
+
+const custom404File = "ipfs-404.html"
+
+// serve404Page looks for a file named ipfs-404.html in the nearest
+// ancestor directory of urlPath and, if found, serves it with HTTP 404
+// instead of the generic "path resolve error" response. This mirrors the
+// SPA/static-site 404 behavior of other IPFS gateways. It only applies
+// when the client is asking for HTML; anything else falls through to the
+// plain-text error.
+func (i *gatewayHandler) serve404Page(w http.ResponseWriter, r *http.Request, urlPath string) bool {
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	// segments[0:2] is the "ipfs"/"ipns" + root identifier; nothing above
+	// that to walk up to.
+	for end := len(segments) - 1; end >= 2; end-- {
+		ancestor := "/" + strings.Join(segments[:end], "/")
+		nd, _, err := i.resolve(r, ancestor)
+		if err != nil {
+			continue
+		}
+		pn, ok := nd.(*dag.ProtoNode)
+		if !ok {
+			continue
+		}
+		link := findLink(pn, custom404File)
+		if link == nil {
+			continue
+		}
+		fileNode, err := i.node.DAG.Get(r.Context(), link.Cid)
+		if err != nil {
+			continue
+		}
+		body := fileNode.RawData()
+		if filePN, ok := fileNode.(*dag.ProtoNode); ok {
+			dr, err := uio.NewDagReader(r.Context(), filePN, i.node.DAG)
+			if err != nil {
+				continue
+			}
+			if body, err = ioutil.ReadAll(dr); err != nil {
+				continue
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Etag", fmt.Sprintf("%q", link.Cid.String()))
+		w.Header().Set("X-Ipfs-Path", urlPath)
+		w.WriteHeader(http.StatusNotFound)
+		w.Write(body)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package corehttp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	ipath "github.com/ipfs/go-ipfs/path"
+
+	cid "gx/ipfs/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+)
+
+// pathRoots returns the CID resolved at every segment of urlPath, in
+// order, ending with terminal - useful as the value of X-Ipfs-Roots so a
+// cache can invalidate a response when any mutable middle segment (an
+// IPNS name partway down the path) changes. It goes through resolveRoot
+// so an /ipns/<name>/a/b request resolves <name> exactly once, the same
+// way the main resolve() path does, instead of trying to ResolvePath an
+// IPNS name as if it were a CID.
+func (i *gatewayHandler) pathRoots(ctx context.Context, urlPath string, terminal cid.Cid) ([]cid.Cid, error) {
+	root, rest, err := i.resolveRoot(ctx, urlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]ipath.Path, 0, len(rest)+1)
+	cur := root
+	for _, seg := range rest {
+		segments = append(segments, cur)
+		cur = ipath.FromString(cur.String() + "/" + seg)
+	}
+
+	roots := make([]cid.Cid, 0, len(segments)+1)
+	for _, p := range segments {
+		nd, err := i.node.Resolver.ResolvePath(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, nd.Cid())
+	}
+	roots = append(roots, terminal)
+	return roots, nil
+}
+
+func joinCids(cids []cid.Cid) string {
+	ss := make([]string, len(cids))
+	for i, c := range cids {
+		ss[i] = c.String()
+	}
+	return strings.Join(ss, ",")
+}
+
+func strongEtag(c cid.Cid) string {
+	return fmt.Sprintf("%q", c.String())
+}
+
+func weakEtag(c cid.Cid) string {
+	return fmt.Sprintf("W/%q", c.String())
+}
+
+// ifNoneMatchHits reports whether an If-None-Match header (which may list
+// several comma-separated, possibly weak, entity tags) already contains
+// the resolved CID, meaning the client's cached copy is still good.
+func ifNoneMatchHits(header string, resolved cid.Cid) bool {
+	if header == "" {
+		return false
+	}
+	target := resolved.String()
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		tag = strings.TrimPrefix(tag, "W/")
+		tag = strings.Trim(tag, `"`)
+		if tag == target {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package corehttp
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"io/ioutil"
@@ -18,7 +19,10 @@ import (
 	repo "github.com/ipfs/go-ipfs/repo"
 	config "github.com/ipfs/go-ipfs/repo/config"
 	testutil "github.com/ipfs/go-ipfs/thirdparty/testutil"
+	ft "github.com/ipfs/go-ipfs/unixfs"
 
+	cid "gx/ipfs/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	mbase "gx/ipfs/QmcxkxTVuURV2Ppegx5fG8yBgqNAJ4VgA6sqHKtPt4gHAs/go-multibase"
 	id "gx/ipfs/QmdzDdLZ7nj133QvNHypyS9Y39g35bMFk5DJ2pmX7YqtKU/go-libp2p/p2p/protocol/identify"
 	ci "gx/ipfs/QmfWDLQjGjVe4fr5CoztYW2DYYjRysMJrFe1RCsXLPTf46/go-libp2p-crypto"
 )
@@ -59,7 +63,10 @@ func newNodeWithMockNamesys(ns mockNamesys) (*core.IpfsNode, error) {
 	if err != nil {
 		return nil, err
 	}
-	n.Namesys = ns
+	// proquint names never touch the network, so they're tried before
+	// falling through to whatever resolver (here, the mock) handles
+	// DNS/DHT-style names.
+	n.Namesys = namesys.NewMultiResolver(ns, namesys.ProquintResolver{}, ns)
 	return n, nil
 }
 
@@ -85,7 +92,18 @@ func doWithoutRedirect(req *http.Request) (*http.Response, error) {
 	return res, nil
 }
 
-func newTestServerAndNode(t *testing.T, ns mockNamesys) (*httptest.Server, *core.IpfsNode) {
+// gatewayTestOptions lets individual tests toggle gateway behavior that
+// would otherwise require touching every newTestServerAndNode call site.
+// A nil *gatewayTestOptions means "use the defaults".
+type gatewayTestOptions struct {
+	DeserializedResponses bool
+}
+
+func newTestServerAndNode(t *testing.T, ns mockNamesys, opts *gatewayTestOptions) (*httptest.Server, *core.IpfsNode) {
+	if opts == nil {
+		opts = &gatewayTestOptions{DeserializedResponses: true}
+	}
+
 	n, err := newNodeWithMockNamesys(ns)
 	if err != nil {
 		t.Fatal(err)
@@ -96,6 +114,7 @@ func newTestServerAndNode(t *testing.T, ns mockNamesys) (*httptest.Server, *core
 		t.Fatal(err)
 	}
 	cfg.Gateway.PathPrefixes = []string{"/good-prefix"}
+	cfg.Gateway.DeserializedResponses = opts.DeserializedResponses
 
 	// need this variable here since we need to construct handler with
 	// listener, and server with handler. yay cycles.
@@ -115,9 +134,205 @@ func newTestServerAndNode(t *testing.T, ns mockNamesys) (*httptest.Server, *core
 	return ts, n
 }
 
+func newTestServerAndNodeWithSubdomains(t *testing.T, ns mockNamesys, gatewayHost string) (*httptest.Server, *core.IpfsNode) {
+	return newTestServerAndNodeWithSubdomainSpec(t, ns, gatewayHost, config.GatewaySpec{UseSubdomains: true})
+}
+
+func newTestServerAndNodeWithSubdomainSpec(t *testing.T, ns mockNamesys, gatewayHost string, spec config.GatewaySpec) (*httptest.Server, *core.IpfsNode) {
+	n, err := newNodeWithMockNamesys(ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Gateway.PublicGateways = map[string]config.GatewaySpec{
+		gatewayHost: spec,
+	}
+	cfg.Gateway.DeserializedResponses = true
+
+	dh := &delegatedHandler{}
+	ts := httptest.NewServer(dh)
+
+	dh.Handler, err = makeHandler(n,
+		ts.Listener,
+		VersionOption(),
+		IPNSHostnameOption(),
+		SubdomainGatewayOption(),
+		GatewayOption(false, "/ipfs", "/ipns"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ts, n
+}
+
+func TestSubdomainGatewayRedirectAndCORS(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNodeWithSubdomains(t, ns, "dweb.link")
+	defer ts.Close()
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "dweb.link"
+
+	res, err := doWithoutRedirect(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusMovedPermanently {
+		t.Fatalf("status is %d, expected 301", res.StatusCode)
+	}
+
+	v1, err := cid.Decode(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantID, err := cid.NewCidV1(cid.DagProtobuf, v1.Hash()).StringOfBase(mbase.Base32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLocation := "//" + wantID + ".ipfs.dweb.link"
+	if loc := res.Header.Get("Location"); loc != wantLocation {
+		t.Errorf("got Location %q, expected %q", loc, wantLocation)
+	}
+
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected CORS header on redirect response, got %q", got)
+	}
+	if got := res.Header.Get("Access-Control-Allow-Methods"); got != "GET" {
+		t.Errorf("expected CORS methods header on redirect response, got %q", got)
+	}
+}
+
+func TestSubdomainGatewayRewrite(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNodeWithSubdomains(t, ns, "dweb.link")
+	defer ts.Close()
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = k + ".ipfs.dweb.link"
+
+	var c http.Client
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "fnord" {
+		t.Errorf("got %q, expected content served from origin-isolated subdomain", body)
+	}
+}
+
+func TestSubdomainGatewayRestrictedNamespaces(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNodeWithSubdomainSpec(t, ns, "dweb.link", config.GatewaySpec{
+		UseSubdomains: true,
+		Paths:         []string{"/ipfs"},
+		NoDNSLink:     true,
+	})
+	defer ts.Close()
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/examplename"] = path.FromString("/ipfs/" + k)
+
+	// /ipfs is in Paths, so a plain-path request still redirects to the
+	// origin-isolated subdomain.
+	req, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "dweb.link"
+	res, err := doWithoutRedirect(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("got %d, expected 301 redirect for an /ipfs path allowed by Paths", res.StatusCode)
+	}
+
+	// /ipns is excluded by NoDNSLink, so it must not be redirected to a
+	// "<name>.ipns.dweb.link" subdomain.
+	req2, err := http.NewRequest("GET", ts.URL+"/ipns/examplename", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req2.Host = "dweb.link"
+	res2, err := doWithoutRedirect(req2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res2.StatusCode == http.StatusMovedPermanently {
+		t.Errorf("got 301 redirect for /ipns, expected it to be denied by NoDNSLink")
+	}
+
+	// an already-subdomained "<name>.ipns.dweb.link" request must also be
+	// refused rather than rewritten to /ipns/<name>.
+	req3, err := http.NewRequest("GET", ts.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req3.Host = "examplename.ipns.dweb.link"
+	res3, err := doWithoutRedirect(req3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res3.StatusCode == http.StatusOK {
+		t.Errorf("got 200 for an ipns subdomain request, expected it to be denied by NoDNSLink")
+	}
+}
+
+// encodeProquint is the inverse of namesys's proquint decoding, used here
+// to build a well-formed proquint name for an arbitrary (even-length)
+// byte string without hand-computing one.
+func encodeProquint(data []byte) string {
+	const consonants = "bdfghjklmnprstvz"
+	const vowels = "aiou"
+	var words []string
+	for i := 0; i < len(data); i += 2 {
+		v := uint16(data[i])<<8 | uint16(data[i+1])
+		word := []byte{
+			consonants[(v>>12)&0xf],
+			vowels[(v>>10)&0x3],
+			consonants[(v>>6)&0xf],
+			vowels[(v>>4)&0x3],
+			consonants[v&0xf],
+		}
+		words = append(words, string(word))
+	}
+	return strings.Join(words, "-")
+}
+
 func TestGatewayGet(t *testing.T) {
 	ns := mockNamesys{}
-	ts, n := newTestServerAndNode(t, ns)
+	ts, n := newTestServerAndNode(t, ns, nil)
 	defer ts.Close()
 
 	k, err := coreunix.Add(n, strings.NewReader("fnord"))
@@ -126,6 +341,11 @@ func TestGatewayGet(t *testing.T) {
 	}
 	ns["/ipns/example.com"] = path.FromString("/ipfs/" + k)
 
+	// a proquint name should resolve straight to "/ipfs/"+k with no entry
+	// in ns at all, proving ProquintResolver is reachable through the
+	// gateway's normal /ipns/ handling.
+	proquint := encodeProquint([]byte("/ipfs/" + k))
+
 	t.Log(ts.URL)
 	for _, test := range []struct {
 		host   string
@@ -138,6 +358,7 @@ func TestGatewayGet(t *testing.T) {
 		{"localhost:15001", "/ipfs/" + k, http.StatusOK, "fnord"},
 		{"localhost:15001", "/ipns/nxdomain.example.com", http.StatusInternalServerError, "Path Resolve error: " + namesys.ErrResolveFailed.Error()},
 		{"localhost:15001", "/ipns/example.com", http.StatusOK, "fnord"},
+		{"localhost:15001", "/ipns/" + proquint, http.StatusOK, "fnord"},
 		{"example.com", "/", http.StatusOK, "fnord"},
 	} {
 		var c http.Client
@@ -166,12 +387,23 @@ func TestGatewayGet(t *testing.T) {
 			t.Errorf("unexpected response body from %s: expected %q; got %q", urlstr, test.text, body)
 			continue
 		}
+		if test.status == http.StatusOK {
+			if got := resp.Header.Get("X-Ipfs-Path"); got == "" {
+				t.Errorf("missing X-Ipfs-Path header from %s", urlstr)
+			}
+			if got := resp.Header.Get("X-Ipfs-Roots"); !strings.Contains(got, k) {
+				t.Errorf("expected X-Ipfs-Roots to contain %s, got %q from %s", k, got, urlstr)
+			}
+			if got, want := resp.Header.Get("Etag"), `"`+k+`"`; got != want {
+				t.Errorf("got Etag %q, expected %q from %s", got, want, urlstr)
+			}
+		}
 	}
 }
 
 func TestIPNSHostnameRedirect(t *testing.T) {
 	ns := mockNamesys{}
-	ts, n := newTestServerAndNode(t, ns)
+	ts, n := newTestServerAndNode(t, ns, nil)
 	t.Logf("test server url: %s", ts.URL)
 	defer ts.Close()
 
@@ -255,7 +487,7 @@ func TestIPNSHostnameRedirect(t *testing.T) {
 
 func TestIPNSHostnameBacklinks(t *testing.T) {
 	ns := mockNamesys{}
-	ts, n := newTestServerAndNode(t, ns)
+	ts, n := newTestServerAndNode(t, ns, nil)
 	t.Logf("test server url: %s", ts.URL)
 	defer ts.Close()
 
@@ -324,6 +556,9 @@ func TestIPNSHostnameBacklinks(t *testing.T) {
 	if !strings.Contains(s, "<a href=\"/foo%3F%20%23%3C%27/file.txt\">") {
 		t.Fatalf("expected file in directory listing")
 	}
+	if want := `W/"` + dagn2.Cid().String() + `"`; res.Header.Get("Etag") != want {
+		t.Errorf("got Etag %q, expected weak etag %q for directory listing", res.Header.Get("Etag"), want)
+	}
 
 	// make request to directory listing at root
 	req, err = http.NewRequest("GET", ts.URL, nil)
@@ -385,6 +620,31 @@ func TestIPNSHostnameBacklinks(t *testing.T) {
 		t.Fatalf("expected file in directory listing")
 	}
 
+	// a file several segments deep under an IPNS name must report a root
+	// for every intermediate directory walked to reach it - each of those
+	// is resolved via the node's path resolver after the IPNS name itself
+	// is resolved via Namesys exactly once, at the start.
+	req, err = http.NewRequest("GET", ts.URL+"/foo%3F%20%23%3C%27/bar/file.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "example.net"
+
+	res, err = doWithoutRedirect(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	leafCid := dagn3.(*dag.ProtoNode).Links()[0].Cid
+	wantRoots := strings.Join([]string{dagn1.Cid().String(), dagn2.Cid().String(), dagn3.Cid().String(), leafCid.String()}, ",")
+	if res.Header.Get("X-Ipfs-Roots") != wantRoots {
+		t.Errorf("got X-Ipfs-Roots %q, expected %q for a file nested under an IPNS name", res.Header.Get("X-Ipfs-Roots"), wantRoots)
+	}
+	if body, err := ioutil.ReadAll(res.Body); err != nil || string(body) != "3" {
+		t.Errorf("got body %q, err %v; expected the nested file's own content", body, err)
+	}
+
 	// make request to directory listing with prefix
 	req, err = http.NewRequest("GET", ts.URL, nil)
 	if err != nil {
@@ -461,11 +721,198 @@ func TestIPNSHostnameBacklinks(t *testing.T) {
 	}
 }
 
+func TestGateway404Page(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNode(t, ns, nil)
+	defer ts.Close()
+
+	// /ipns/example.net/ipfs-404.html, /ipns/example.net/sub/ (no 404 page
+	// of its own, so lookup should walk up to the root's).
+	_, pageDir, err := coreunix.AddWrapped(n, strings.NewReader("<html>not found here</html>"), "ipfs-404.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// AddWrapped returns the directory wrapping the file; the page itself
+	// is that directory's only link.
+	page, err := n.DAG.Get(context.Background(), pageDir.(*dag.ProtoNode).Links()[0].Cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, file, err := coreunix.AddWrapped(n, strings.NewReader("hi"), "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, sub, err := coreunix.AddWrapped(n, strings.NewReader("_"), "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.(*dag.ProtoNode).AddNodeLink("file.txt", file)
+	_, root2, err := coreunix.AddWrapped(n, strings.NewReader("_"), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root2.(*dag.ProtoNode).AddNodeLink("sub", sub)
+	root2.(*dag.ProtoNode).AddNodeLink("ipfs-404.html", page)
+
+	if _, err := n.DAG.Add(page); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.DAG.Add(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.DAG.Add(sub); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.DAG.Add(root2); err != nil {
+		t.Fatal(err)
+	}
+
+	k := root2.Cid()
+	ns["/ipns/example.net"] = path.FromString("/ipfs/" + k.String())
+
+	// (a) missing subpath under the IPNS host falls back to the 404 page
+	req, err := http.NewRequest("GET", ts.URL+"/sub/missing.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "example.net"
+	req.Header.Set("Accept", "text/html")
+
+	var c http.Client
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %d, expected 404", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), "not found here") {
+		t.Errorf("expected custom 404 page body, got %q", body)
+	}
+
+	// (b) non-HTML Accept still gets the plain text error
+	req2, err := http.NewRequest("GET", ts.URL+"/sub/missing.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req2.Host = "example.net"
+	req2.Header.Set("Accept", "text/plain")
+	resp2, err := c.Do(req2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	body2, err := ioutil.ReadAll(resp2.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body2), "not found here") {
+		t.Errorf("expected plain error for non-HTML accept, got %q", body2)
+	}
+}
+
+func TestGatewayConditionalGet(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNode(t, ns, nil)
+	defer ts.Close()
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString("/ipfs/" + k)
+
+	var c http.Client
+
+	// /ipfs/<cid> is immutable: its strong etag is always reusable.
+	req, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", `"`+k+`"`)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("got %d, expected 304 for matching /ipfs/ etag", resp.StatusCode)
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); len(body) != 0 {
+		t.Errorf("expected empty body on 304, got %q", body)
+	}
+
+	// /ipns/<name> is mutable: the etag reflects the currently resolved
+	// CID, so a stale If-None-Match must not short-circuit the response.
+	req2, err := http.NewRequest("GET", ts.URL+"/ipns/example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req2.Host = "localhost:15001"
+	req2.Header.Set("If-None-Match", `"bafynotarealcid"`)
+	resp2, err := c.Do(req2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	if resp2.StatusCode != http.StatusOK {
+		t.Errorf("got %d, expected 200 for stale /ipns/ etag", resp2.StatusCode)
+	}
+
+	req3, err := http.NewRequest("GET", ts.URL+"/ipns/example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req3.Header.Set("If-None-Match", `"`+k+`"`)
+	resp3, err := c.Do(req3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp3.Body.Close()
+	if resp3.StatusCode != http.StatusNotModified {
+		t.Errorf("got %d, expected 304 for /ipns/ etag matching the currently resolved CID", resp3.StatusCode)
+	}
+
+	// a directory's Etag is weak (its listing is gateway-rendered, not
+	// raw content), so a matching If-None-Match must get a 304 back with
+	// that same weak Etag, not the strong form a file uses.
+	_, dagn, err := coreunix.AddWrapped(n, strings.NewReader("fnord"), "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.DAG.Add(dagn); err != nil {
+		t.Fatal(err)
+	}
+	dirCid := dagn.Cid().String()
+
+	req4, err := http.NewRequest("GET", ts.URL+"/ipfs/"+dirCid+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req4.Header.Set("If-None-Match", `W/"`+dirCid+`"`)
+	resp4, err := c.Do(req4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp4.Body.Close()
+	if resp4.StatusCode != http.StatusNotModified {
+		t.Errorf("got %d, expected 304 for matching directory etag", resp4.StatusCode)
+	}
+	if want := `W/"` + dirCid + `"`; resp4.Header.Get("Etag") != want {
+		t.Errorf("got Etag %q on directory 304, expected weak etag %q", resp4.Header.Get("Etag"), want)
+	}
+}
+
 func TestVersion(t *testing.T) {
 	config.CurrentCommit = "theshortcommithash"
 
 	ns := mockNamesys{}
-	ts, _ := newTestServerAndNode(t, ns)
+	ts, _ := newTestServerAndNode(t, ns, nil)
 	t.Logf("test server url: %s", ts.URL)
 	defer ts.Close()
 
@@ -496,3 +943,285 @@ func TestVersion(t *testing.T) {
 		t.Fatalf("response doesn't contain protocol version:\n%s", s)
 	}
 }
+
+// readCarSections splits a CARv1 byte stream into its varint-length-
+// prefixed sections, returning the raw bytes of each (the header is
+// sections[0]).
+func readCarSections(t *testing.T, body []byte) [][]byte {
+	var sections [][]byte
+	br := bufio.NewReader(strings.NewReader(string(body)))
+	for {
+		l, err := readUvarint(br)
+		if err != nil {
+			break
+		}
+		buf := make([]byte, l)
+		if _, err := readFull(br, buf); err != nil {
+			t.Fatalf("short CAR section: %s", err)
+		}
+		sections = append(sections, buf)
+	}
+	return sections
+}
+
+func readUvarint(br *bufio.Reader) (uint64, error) {
+	var x uint64
+	var s uint
+	for i := 0; ; i++ {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b < 0x80 {
+			return x | uint64(b)<<s, nil
+		}
+		x |= uint64(b&0x7f) << s
+		s += 7
+	}
+}
+
+func readFull(br *bufio.Reader, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		m, err := br.Read(buf[n:])
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func TestGatewayCAR(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNode(t, ns, nil)
+	defer ts.Close()
+
+	k, err := coreunix.Add(n, strings.NewReader("fnord"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+
+	var c http.Client
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/vnd.ipld.car; version=1" {
+		t.Errorf("unexpected Content-Type: %s", ct)
+	}
+	wantEtag := `"` + k + `"`
+	if et := resp.Header.Get("Etag"); et != wantEtag {
+		t.Errorf("got Etag %q, expected %q", et, wantEtag)
+	}
+	if xp := resp.Header.Get("X-Ipfs-Path"); xp != "/ipfs/"+k {
+		t.Errorf("got X-Ipfs-Path %q, expected %q on a CAR response", xp, "/ipfs/"+k)
+	}
+	if xr := resp.Header.Get("X-Ipfs-Roots"); xr != k {
+		t.Errorf("got X-Ipfs-Roots %q, expected %q on a CAR response", xr, k)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sections := readCarSections(t, body)
+	if len(sections) < 2 {
+		t.Fatalf("expected a header plus at least one block, got %d sections", len(sections))
+	}
+
+	// first data section after the header is the root block; decoding
+	// its raw bytes back should give the original file content.
+	cidLen, rootCid, err := cid.CidFromBytes(sections[1])
+	if err != nil {
+		t.Fatalf("invalid CID in CAR block: %s", err)
+	}
+	if rootCid.String() != k {
+		t.Errorf("root block CID %s does not match requested %s", rootCid, k)
+	}
+	rootNode, err := dag.DecodeProtobuf(sections[1][cidLen:])
+	if err != nil {
+		t.Fatalf("root block is not dag-pb: %s", err)
+	}
+	fsNode, err := ft.FromBytes(rootNode.Data())
+	if err != nil {
+		t.Fatalf("root block is not UnixFS: %s", err)
+	}
+	if got := string(fsNode.Data()); got != "fnord" {
+		t.Errorf("root block data %q does not decode back to file bytes", got)
+	}
+}
+
+func TestGatewayCARDirectory(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNode(t, ns, nil)
+	defer ts.Close()
+
+	_, dagn1, err := coreunix.AddWrapped(n, strings.NewReader("1"), "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = n.DAG.Add(dagn1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := dagn1.Cid()
+
+	req, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k.String()+"/?format=car", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c http.Client
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sections := readCarSections(t, body)
+
+	// default CAR request for a directory only includes the resolution
+	// path (here, just the directory itself) plus its direct children -
+	// not a recursive walk of the whole tree.
+	if len(sections) != 1+1+len(dagn1.(*dag.ProtoNode).Links()) {
+		t.Errorf("expected header + dir + direct children, got %d sections", len(sections))
+	}
+}
+
+func TestGatewayCARIPNSNestedPath(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNode(t, ns, nil)
+	defer ts.Close()
+
+	_, dagn1, err := coreunix.AddWrapped(n, strings.NewReader("1"), "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dagn2, err := coreunix.AddWrapped(n, strings.NewReader("2"), "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dagn1.(*dag.ProtoNode).AddNodeLink("sub", dagn2)
+	if _, err := n.DAG.Add(dagn2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.DAG.Add(dagn1); err != nil {
+		t.Fatal(err)
+	}
+	ns["/ipns/example.com"] = path.FromString("/ipfs/" + dagn1.Cid().String())
+
+	// a CAR request for a path nested under an IPNS name must resolve the
+	// name via Namesys, not try to treat it as a CID; before the fix this
+	// 500ed instead of streaming the file.
+	req, err := http.NewRequest("GET", ts.URL+"/ipns/example.com/sub/file.txt?format=car", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c http.Client
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("expected 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sections := readCarSections(t, body)
+	// header + dagn1 + dagn2 + the leaf "file.txt" content node under sub/
+	if len(sections) != 4 {
+		t.Errorf("expected header + 3 blocks for the nested path, got %d sections", len(sections))
+	}
+}
+
+func TestGatewayTrustlessMode(t *testing.T) {
+	ns := mockNamesys{}
+	ts, n := newTestServerAndNode(t, ns, &gatewayTestOptions{DeserializedResponses: false})
+	defer ts.Close()
+
+	_, dagn1, err := coreunix.AddWrapped(n, strings.NewReader("1"), "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := n.DAG.Add(dagn1); err != nil {
+		t.Fatal(err)
+	}
+	k := dagn1.Cid().String()
+
+	var c http.Client
+
+	// html rendering of a directory listing requires deserializing the
+	// UnixFS directory, which a trustless gateway refuses to do.
+	req, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("got %d, expected 406 for deserialized directory listing in trustless mode", resp.StatusCode)
+	}
+
+	// the raw block itself is always servable, verification work is on
+	// the client.
+	req2, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req2.Header.Set("Accept", "application/vnd.ipld.raw")
+	resp2, err := c.Do(req2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp2.Body.Close()
+	if resp2.StatusCode != http.StatusOK {
+		t.Errorf("got %d, expected 200 for raw block request in trustless mode", resp2.StatusCode)
+	}
+	if ct := resp2.Header.Get("Content-Type"); ct != "application/vnd.ipld.raw" {
+		t.Errorf("unexpected Content-Type: %s", ct)
+	}
+
+	// a missing path would normally fall back to serve404Page, which
+	// decodes DAG-PB nodes while walking ancestor directories looking for
+	// a custom 404 page; a trustless gateway must refuse that too.
+	req3, err := http.NewRequest("GET", ts.URL+"/ipfs/"+k+"/missing", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req3.Header.Set("Accept", "text/html")
+	resp3, err := c.Do(req3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp3.Body.Close()
+	if resp3.StatusCode != http.StatusNotAcceptable {
+		t.Errorf("got %d, expected 406 for a missing path in trustless mode", resp3.StatusCode)
+	}
+}
@@ -0,0 +1,48 @@
+package corehttp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	namesys "github.com/ipfs/go-ipfs/namesys"
+)
+
+// IPNSHostnameOption rewrites requests whose Host header resolves via
+// IPNS (e.g. a DNSLink domain like "example.com", or a raw peer ID) into
+// the equivalent /ipns/<name>/... request, so that a site can be served
+// from its own domain without a /ipns/ prefix in the URL.
+func IPNSHostnameOption() ServeOption {
+	return func(n *core.IpfsNode, l net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		childMux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			host := stripPort(r.Host)
+			if len(host) > 0 && isIPNSHostname(n, host) {
+				r.Header["X-Ipns-Original-Path"] = []string{r.URL.Path}
+				r.URL.Path = "/ipns/" + host + r.URL.Path
+			}
+			childMux.ServeHTTP(w, r)
+		})
+		return childMux, nil
+	}
+}
+
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// isIPNSHostname reports whether host resolves through the node's
+// Namesys, meaning it should be treated as an IPNS-hosted domain rather
+// than the gateway's own hostname.
+func isIPNSHostname(n *core.IpfsNode, host string) bool {
+	if strings.HasPrefix(host, "www.") {
+		host = host[4:]
+	}
+	_, err := n.Namesys.Resolve(context.Background(), "/ipns/"+host)
+	return err == nil
+}
@@ -0,0 +1,121 @@
+package corehttp
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	core "github.com/ipfs/go-ipfs/core"
+	config "github.com/ipfs/go-ipfs/repo/config"
+
+	cid "gx/ipfs/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+	mbase "gx/ipfs/QmcxkxTVuURV2Ppegx5fG8yBgqNAJ4VgA6sqHKtPt4gHAs/go-multibase"
+)
+
+var subdomainHostPattern = regexp.MustCompile(`^(?P<id>[^.]+)\.(?P<ns>ipfs|ipns)\.(?P<gateway>.+)$`)
+var directPathPattern = regexp.MustCompile(`^/(?P<ns>ipfs|ipns)/(?P<id>[^/]+)(?P<rest>/.*)?$`)
+
+// SubdomainGatewayOption returns a ServeOption that, for any hostname
+// listed under Gateway.PublicGateways with UseSubdomains set, treats
+// "<cid>.ipfs.<gateway>" and "<name>.ipns.<gateway>" as origin-isolated
+// content roots, and redirects plain "/ipfs/<cid>" / "/ipns/<name>"
+// requests on that host to their subdomain equivalent.
+func SubdomainGatewayOption() ServeOption {
+	return func(n *core.IpfsNode, l net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		childMux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			host := stripPort(r.Host)
+			gw, gwHost, ok := matchPublicSubdomainGateway(n, host)
+			if !ok {
+				childMux.ServeHTTP(w, r)
+				return
+			}
+
+			if m := subdomainHostPattern.FindStringSubmatch(host); m != nil && m[3] == gwHost {
+				ns, id := m[2], m[1]
+				if !namespaceAllowed(gw, ns) {
+					childMux.ServeHTTP(w, r)
+					return
+				}
+				r.URL.Path = "/" + ns + "/" + id + r.URL.Path
+				childMux.ServeHTTP(w, r)
+				return
+			}
+
+			if gw.UseSubdomains {
+				if m := directPathPattern.FindStringSubmatch(r.URL.Path); m != nil {
+					ns, id, rest := m[1], m[2], m[3]
+					if namespaceAllowed(gw, ns) {
+						if ns == "ipfs" {
+							if converted, err := toSubdomainSafeID(id); err == nil {
+								id = converted
+							}
+						}
+						setCORSHeaders(w)
+						http.Redirect(w, r, "//"+id+"."+ns+"."+gwHost+rest, http.StatusMovedPermanently)
+						return
+					}
+				}
+			}
+
+			childMux.ServeHTTP(w, r)
+		})
+		return childMux, nil
+	}
+}
+
+// matchPublicSubdomainGateway finds the Gateway.PublicGateways entry (if
+// any) whose hostname suffix matches host, either directly or as the
+// "<gateway>" portion of a "<id>.ipfs.<gateway>" / "<id>.ipns.<gateway>"
+// subdomain.
+func matchPublicSubdomainGateway(n *core.IpfsNode, host string) (config.GatewaySpec, string, bool) {
+	cfg, err := n.Repo.Config()
+	if err != nil {
+		return config.GatewaySpec{}, "", false
+	}
+	if gw, ok := cfg.Gateway.PublicGateways[host]; ok {
+		return gw, host, true
+	}
+	if m := subdomainHostPattern.FindStringSubmatch(host); m != nil {
+		if gw, ok := cfg.Gateway.PublicGateways[m[3]]; ok {
+			return gw, m[3], true
+		}
+	}
+	return config.GatewaySpec{}, "", false
+}
+
+// namespaceAllowed reports whether gw permits subdomain-style access to
+// the "ipfs" or "ipns" namespace: Gateway.Paths restricts a public
+// gateway to an explicit subset of namespaces (an empty list means both
+// are allowed), and NoDNSLink additionally forbids "ipns", since that
+// namespace is what lets a request name an arbitrary mutable record
+// instead of sticking to the gateway's own content.
+func namespaceAllowed(gw config.GatewaySpec, ns string) bool {
+	if ns == "ipns" && gw.NoDNSLink {
+		return false
+	}
+	if len(gw.Paths) == 0 {
+		return true
+	}
+	for _, p := range gw.Paths {
+		if strings.Trim(p, "/") == ns {
+			return true
+		}
+	}
+	return false
+}
+
+// toSubdomainSafeID encodes id as a CIDv1 in lowercase base32 when it
+// isn't already DNS-label-safe (CIDv0 is base58 and contains characters
+// invalid in a hostname label).
+func toSubdomainSafeID(id string) (string, error) {
+	c, err := cid.Decode(id)
+	if err != nil {
+		return id, err
+	}
+	if c.Version() == 0 {
+		c = cid.NewCidV1(cid.DagProtobuf, c.Hash())
+	}
+	return c.StringOfBase(mbase.Base32)
+}
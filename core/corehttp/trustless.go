@@ -0,0 +1,48 @@
+package corehttp
+
+import (
+	"net/http"
+	"strings"
+
+	dag "github.com/ipfs/go-ipfs/merkledag"
+
+	cid "gx/ipfs/QmR8BauakNcBa3RbE4nbQu76PDiJgoQgz8AJdhJuiU4TAw/go-cid"
+)
+
+const rawContentType = "application/vnd.ipld.raw"
+
+// isRawRequest reports whether the client asked for the raw block
+// encoding, either via Accept negotiation or the ?format= query
+// shortcut.
+func isRawRequest(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "raw" {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), rawContentType)
+}
+
+// serveTrustless handles a request on a Gateway.DeserializedResponses=false
+// gateway: it serves the resolved node's raw bytes when the client asked
+// for them, and refuses everything else with 406, since anything else
+// (a directory listing, a decoded file, a HAMT-sharded directory) would
+// require the gateway to do verification work on the client's behalf.
+func (i *gatewayHandler) serveTrustless(w http.ResponseWriter, r *http.Request, nd dag.Node, resolved cid.Cid) {
+	if !isRawRequest(r) {
+		refuseDeserialized(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", rawContentType)
+	w.Header().Set("Etag", strongEtag(resolved))
+	w.Write(nd.RawData())
+}
+
+// refuseDeserialized answers a request that a Gateway.DeserializedResponses=false
+// gateway cannot safely fulfil, e.g. a UnixFS directory listing, a decoded
+// file, or (via serve404Page) a DAG-PB walk for a custom 404 page — any of
+// which would require the gateway to do verification work on the client's
+// behalf.
+func refuseDeserialized(w http.ResponseWriter) {
+	http.Error(w, "deserialized responses are disabled for this gateway; "+
+		"request Accept: application/vnd.ipld.raw or application/vnd.ipld.car", http.StatusNotAcceptable)
+}
@@ -0,0 +1,31 @@
+package corehttp
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+
+	core "github.com/ipfs/go-ipfs/core"
+	config "github.com/ipfs/go-ipfs/repo/config"
+
+	id "gx/ipfs/QmdzDdLZ7nj133QvNHypyS9Y39g35bMFk5DJ2pmX7YqtKU/go-libp2p/p2p/protocol/identify"
+)
+
+// VersionOption serves a plain-text summary of the daemon's build and
+// protocol versions at /version, mainly for debugging which commit a
+// gateway in the wild is running.
+func VersionOption() ServeOption {
+	return func(n *core.IpfsNode, l net.Listener, mux *http.ServeMux) (*http.ServeMux, error) {
+		mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintf(w, "Commit: %s\n", config.CurrentCommit)
+			fmt.Fprintf(w, "Client Version: %s\n", id.ClientVersion)
+			fmt.Fprintf(w, "Protocol Version: %s\n", id.LibP2PVersion)
+			fmt.Fprintf(w, "Go Version: %s\n", runtime.Version())
+			fmt.Fprintf(w, "OS: %s\n", runtime.GOOS)
+			fmt.Fprintf(w, "Arch: %s\n", runtime.GOARCH)
+		})
+		return mux, nil
+	}
+}
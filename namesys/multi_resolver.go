@@ -0,0 +1,60 @@
+package namesys
+
+import (
+	"context"
+	"time"
+
+	path "github.com/ipfs/go-ipfs/path"
+
+	ci "gx/ipfs/QmfWDLQjGjVe4fr5CoztYW2DYYjRysMJrFe1RCsXLPTf46/go-libp2p-crypto"
+)
+
+// NameSystem is the interface satisfied by anything that can resolve and
+// publish IPNS-style names, e.g. the DNS/DHT-backed default
+// implementation, mocks used in tests, or ProquintResolver.
+type NameSystem interface {
+	Resolve(ctx context.Context, name string) (path.Path, error)
+	ResolveN(ctx context.Context, name string, depth int) (path.Path, error)
+	Publish(ctx context.Context, name ci.PrivKey, value path.Path) error
+	PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.Path, eol time.Time) error
+}
+
+// MultiResolver tries each of its resolvers' Resolve/ResolveN in order,
+// returning the first successful result. Publish/PublishWithEOL are not
+// multiplexed: they always go to publisher, the system that actually owns
+// mutable names (proquint names, for instance, can never be published
+// to).
+type MultiResolver struct {
+	resolvers []NameSystem
+	publisher NameSystem
+}
+
+// NewMultiResolver composes resolvers, trying them in the given order,
+// while routing all Publish calls to publisher.
+func NewMultiResolver(publisher NameSystem, resolvers ...NameSystem) *MultiResolver {
+	return &MultiResolver{resolvers: resolvers, publisher: publisher}
+}
+
+func (m *MultiResolver) Resolve(ctx context.Context, name string) (path.Path, error) {
+	return m.ResolveN(ctx, name, DefaultDepthLimit)
+}
+
+func (m *MultiResolver) ResolveN(ctx context.Context, name string, depth int) (path.Path, error) {
+	var lastErr error = ErrResolveFailed
+	for _, r := range m.resolvers {
+		p, err := r.ResolveN(ctx, name, depth)
+		if err == nil {
+			return p, nil
+		}
+		lastErr = err
+	}
+	return "", lastErr
+}
+
+func (m *MultiResolver) Publish(ctx context.Context, name ci.PrivKey, value path.Path) error {
+	return m.publisher.Publish(ctx, name, value)
+}
+
+func (m *MultiResolver) PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.Path, eol time.Time) error {
+	return m.publisher.PublishWithEOL(ctx, name, value, eol)
+}
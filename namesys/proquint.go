@@ -0,0 +1,119 @@
+package namesys
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	path "github.com/ipfs/go-ipfs/path"
+
+	ci "gx/ipfs/QmfWDLQjGjVe4fr5CoztYW2DYYjRysMJrFe1RCsXLPTf46/go-libp2p-crypto"
+)
+
+const proquintConsonants = "bdfghjklmnprstvz"
+const proquintVowels = "aiou"
+
+// proquintWordPattern matches a single proquint word: consonant, vowel,
+// consonant, vowel, consonant.
+var proquintWordPattern = "[" + proquintConsonants + "][" + proquintVowels + "][" + proquintConsonants + "][" + proquintVowels + "][" + proquintConsonants + "]"
+var proquintPattern = regexp.MustCompile("^" + proquintWordPattern + "(-" + proquintWordPattern + ")*$")
+
+// ErrNotProquint is returned by ProquintResolver.Resolve when the given
+// name doesn't match the proquint encoding pattern at all.
+var ErrNotProquint = errors.New("not a proquint-encoded name")
+
+// ProquintResolver resolves proquint-encoded identifiers
+// (https://arxiv.org/html/0901.4016) directly into a path.Path, with no
+// network lookup: the identifier's bytes decode straight into the value
+// of the path it represents.
+type ProquintResolver struct{}
+
+// Resolve implements Resolver.
+func (r ProquintResolver) Resolve(ctx context.Context, name string) (path.Path, error) {
+	return r.ResolveN(ctx, name, DefaultDepthLimit)
+}
+
+// ResolveN implements Resolver. depth is ignored: a proquint name decodes
+// to its value in a single step.
+func (r ProquintResolver) ResolveN(ctx context.Context, name string, depth int) (path.Path, error) {
+	name = strings.TrimPrefix(name, "/ipns/")
+	if !proquintPattern.MatchString(name) {
+		return "", ErrNotProquint
+	}
+	decoded, err := decodeProquint(name)
+	if err != nil {
+		return "", err
+	}
+	return path.FromString(string(decoded)), nil
+}
+
+// Publish/PublishWithEOL are not meaningful for a proquint name: there is
+// nothing to publish to, the name *is* the value.
+func (r ProquintResolver) Publish(ctx context.Context, name ci.PrivKey, value path.Path) error {
+	return errors.New("cannot publish to a proquint name")
+}
+
+func (r ProquintResolver) PublishWithEOL(ctx context.Context, name ci.PrivKey, value path.Path, eol time.Time) error {
+	return errors.New("cannot publish to a proquint name")
+}
+
+// decodeProquint reverses the proquint encoding: each 5-character word
+// maps to a big-endian uint16 via the fixed consonant/vowel alphabets,
+// and the words concatenate into the underlying byte string.
+func decodeProquint(s string) ([]byte, error) {
+	words := strings.Split(s, "-")
+	out := make([]byte, 0, len(words)*2)
+	for _, w := range words {
+		if len(w) != 5 {
+			return nil, ErrNotProquint
+		}
+		v, err := decodeProquintWord(w)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, byte(v>>8), byte(v))
+	}
+	return out, nil
+}
+
+func decodeProquintWord(w string) (uint16, error) {
+	c0, err := consonantIndex(w[0])
+	if err != nil {
+		return 0, err
+	}
+	v0, err := vowelIndex(w[1])
+	if err != nil {
+		return 0, err
+	}
+	c1, err := consonantIndex(w[2])
+	if err != nil {
+		return 0, err
+	}
+	v1, err := vowelIndex(w[3])
+	if err != nil {
+		return 0, err
+	}
+	c2, err := consonantIndex(w[4])
+	if err != nil {
+		return 0, err
+	}
+	return uint16(c0)<<12 | uint16(v0)<<10 | uint16(c1)<<6 | uint16(v1)<<4 | uint16(c2), nil
+}
+
+func consonantIndex(b byte) (uint16, error) {
+	i := strings.IndexByte(proquintConsonants, b)
+	if i < 0 {
+		return 0, ErrNotProquint
+	}
+	return uint16(i), nil
+}
+
+func vowelIndex(b byte) (uint16, error) {
+	i := strings.IndexByte(proquintVowels, b)
+	if i < 0 {
+		return 0, ErrNotProquint
+	}
+	return uint16(i), nil
+}